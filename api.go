@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// sensorsHandler returns the latest known measurement for every sensor
+// that has reported data since startup.
+func sensorsHandler(cache *SensorCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(cache.All()); err != nil {
+			log.WithError(err).Error("failed to encode sensors response")
+		}
+	}
+}
+
+// sensorLatestHandler returns the latest known measurement for a single
+// sensor id.
+func sensorLatestHandler(cache *SensorCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		data, ok := cache.Get(id)
+		if !ok {
+			http.Error(w, "no data for sensor "+id, http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(data); err != nil {
+			log.WithError(err).Error("failed to encode sensor latest response")
+		}
+	}
+}
+
+// sensorHistoryHandler serves a range query for a single sensor id,
+// issuing it against whichever InfluxDB version is configured.
+func sensorHistoryHandler(querier Querier) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if querier == nil {
+			http.Error(w, "history queries require InfluxDB to be configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+
+		fromParam := r.URL.Query().Get("from")
+		if fromParam == "" {
+			http.Error(w, "from query parameter is required and must be RFC3339", http.StatusBadRequest)
+			return
+		}
+		from, err := time.Parse(time.RFC3339, fromParam)
+		if err != nil {
+			http.Error(w, "invalid from query parameter, must be RFC3339", http.StatusBadRequest)
+			return
+		}
+
+		to := time.Now()
+		if toParam := r.URL.Query().Get("to"); toParam != "" {
+			to, err = time.Parse(time.RFC3339, toParam)
+			if err != nil {
+				http.Error(w, "invalid to query parameter, must be RFC3339", http.StatusBadRequest)
+				return
+			}
+		}
+
+		points, err := querier.History(id, from, to)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"op":    "sensorHistoryHandler",
+				"id":    id,
+				"error": err,
+			}).Error("failed to query sensor history")
+			http.Error(w, "failed to query sensor history", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(points); err != nil {
+			log.WithError(err).Error("failed to encode sensor history response")
+		}
+	}
+}
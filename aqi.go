@@ -0,0 +1,181 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// breakpoint is one row of an EPA AQI breakpoint table: concentrations
+// in [concLo, concHi] map linearly onto AQI values in [aqiLo, aqiHi].
+type breakpoint struct {
+	concLo, concHi float64
+	aqiLo, aqiHi   float64
+}
+
+// EPA breakpoint tables, in ug/m3, from the AQI technical documentation.
+var (
+	pm25Breakpoints = []breakpoint{
+		{0, 12.0, 0, 50},
+		{12.1, 35.4, 51, 100},
+		{35.5, 55.4, 101, 150},
+		{55.5, 150.4, 151, 200},
+		{150.5, 250.4, 201, 300},
+		{250.5, 500.4, 301, 500},
+	}
+
+	pm10Breakpoints = []breakpoint{
+		{0, 54, 0, 50},
+		{55, 154, 51, 100},
+		{155, 254, 101, 150},
+		{255, 354, 151, 200},
+		{355, 424, 201, 300},
+		{425, 604, 301, 500},
+	}
+)
+
+// aqiFromBreakpoints applies the standard EPA piecewise-linear AQI
+// formula: ((I_hi - I_lo)/(C_hi - C_lo)) * (C - C_lo) + I_lo. A
+// concentration above the top of the table is extrapolated from the
+// last row rather than clamped, since it still indicates "worse than
+// the table's worst category" rather than undefined.
+func aqiFromBreakpoints(conc float64, breakpoints []breakpoint) float64 {
+	if math.IsNaN(conc) || conc < 0 {
+		return math.NaN()
+	}
+
+	bp := breakpoints[len(breakpoints)-1]
+	for _, candidate := range breakpoints {
+		if conc <= candidate.concHi {
+			bp = candidate
+			break
+		}
+	}
+
+	return ((bp.aqiHi-bp.aqiLo)/(bp.concHi-bp.concLo))*(conc-bp.concLo) + bp.aqiLo
+}
+
+// PM25AQI computes the US EPA AQI for a PM2.5 concentration in ug/m3.
+func PM25AQI(conc float64) float64 {
+	return aqiFromBreakpoints(conc, pm25Breakpoints)
+}
+
+// PM10AQI computes the US EPA AQI for a PM10 concentration in ug/m3.
+func PM10AQI(conc float64) float64 {
+	return aqiFromBreakpoints(conc, pm10Breakpoints)
+}
+
+// nowCastSample is one hourly bucket tracked for a sensor's NowCast
+// calculation: the running mean of every reading observed during that
+// hour.
+type nowCastSample struct {
+	hour  time.Time
+	sum   float64
+	count int
+}
+
+func (s nowCastSample) mean() float64 {
+	return s.sum / float64(s.count)
+}
+
+// NowCastTracker maintains, per sensor id, a rolling window of the last
+// up-to-12 hourly PM2.5 means used by the EPA NowCast algorithm.
+type NowCastTracker struct {
+	mu      sync.Mutex
+	samples map[string][]nowCastSample
+}
+
+// NewNowCastTracker returns an empty, ready-to-use NowCastTracker.
+func NewNowCastTracker() *NowCastTracker {
+	return &NowCastTracker{
+		samples: make(map[string][]nowCastSample),
+	}
+}
+
+// Add records a PM2.5 reading for id at ts, folding it into the running
+// mean for its hour bucket, and returns the updated NowCast value, or
+// NaN if fewer than 2 of the last 3 hours have data. Samples may arrive
+// out of order (concurrent ingest workers, or interleaved MQTT/cloud/HTTP
+// sources for the same id); Add locates the bucket for ts's hour by its
+// chronological position among the buckets already held for id, rather
+// than assuming ts is always the newest reading seen.
+func (t *NowCastTracker) Add(id string, ts time.Time, pm25 float64) float64 {
+	hour := ts.Truncate(time.Hour)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	samples := t.samples[id]
+
+	// samples is ordered most-recent-hour-first, so the first bucket
+	// whose hour is not after ours is where hour belongs.
+	idx := sort.Search(len(samples), func(i int) bool {
+		return !samples[i].hour.After(hour)
+	})
+
+	if idx < len(samples) && samples[idx].hour.Equal(hour) {
+		samples[idx].sum += pm25
+		samples[idx].count++
+	} else {
+		samples = append(samples, nowCastSample{})
+		copy(samples[idx+1:], samples[idx:])
+		samples[idx] = nowCastSample{hour: hour, sum: pm25, count: 1}
+	}
+
+	if len(samples) > 12 {
+		samples = samples[:12]
+	}
+	t.samples[id] = samples
+
+	return nowCast(samples)
+}
+
+// nowCast implements the EPA NowCast formula over samples, which must be
+// ordered most-recent-hour-first: with w* = min/max over the window,
+// w = max(w*, 0.5), NowCast = Σ(c_i * w^i) / Σ(w^i) for i = 0..n-1.
+func nowCast(samples []nowCastSample) float64 {
+	if len(samples) < 2 {
+		return math.NaN()
+	}
+
+	cutoff := samples[0].hour.Add(-2 * time.Hour)
+	recent := 0
+	for _, s := range samples {
+		if s.hour.Before(cutoff) {
+			break
+		}
+		recent++
+	}
+	if recent < 2 {
+		return math.NaN()
+	}
+
+	min, max := samples[0].mean(), samples[0].mean()
+	for _, s := range samples {
+		m := s.mean()
+		if m < min {
+			min = m
+		}
+		if m > max {
+			max = m
+		}
+	}
+
+	weight := 0.5
+	if max > 0 {
+		if ratio := min / max; ratio > weight {
+			weight = ratio
+		}
+	}
+
+	var weightedSum, weightSum, w float64
+	w = 1
+	for _, s := range samples {
+		weightedSum += s.mean() * w
+		weightSum += w
+		w *= weight
+	}
+
+	return weightedSum / weightSum
+}
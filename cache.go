@@ -0,0 +1,44 @@
+package main
+
+import "sync"
+
+// SensorCache holds the most recently ingested airGradientData for each
+// sensor id, regardless of which source (HTTP, MQTT, cloud API) it came
+// from. It backs the /sensors and /sensors/{id}/latest query handlers.
+type SensorCache struct {
+	mu   sync.RWMutex
+	data map[string]airGradientData
+}
+
+// NewSensorCache returns an empty, ready-to-use SensorCache.
+func NewSensorCache() *SensorCache {
+	return &SensorCache{
+		data: make(map[string]airGradientData),
+	}
+}
+
+// Update records data as the latest measurement for its sensor id.
+func (c *SensorCache) Update(data airGradientData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[data.Id] = data
+}
+
+// Get returns the latest measurement for id, if any has been recorded.
+func (c *SensorCache) Get(id string) (airGradientData, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	data, ok := c.data[id]
+	return data, ok
+}
+
+// All returns the latest measurement for every known sensor id.
+func (c *SensorCache) All() []airGradientData {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	all := make([]airGradientData, 0, len(c.data))
+	for _, data := range c.data {
+		all = append(all, data)
+	}
+	return all
+}
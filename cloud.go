@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/proxy"
+)
+
+const airGradientCloudUrl = "https://api.airgradient.com/public/api/v1/locations/measures/current"
+
+// cloudMeasurement represents one entry of the AirGradient public API's
+// locations/measures/current response.
+type cloudMeasurement struct {
+	LocationId   int     `json:"locationId"`
+	LocationName string  `json:"locationName"`
+	Wifi         int     `json:"wifi"`
+	C02          int     `json:"rco2"`
+	PM01         int     `json:"pm01"`
+	PM02         int     `json:"pm02"`
+	PM10         int     `json:"pm10"`
+	PM003        int     `json:"pm003Count"`
+	TVOC         int     `json:"tvocIndex"`
+	NOX          int     `json:"noxIndex"`
+	Temp         float64 `json:"atmp"`
+	Hum          int     `json:"rhum"`
+}
+
+// PollAirGradientCloud periodically fetches current measurements for
+// config.AirGradientCloud.Locations from the AirGradient public API and
+// pushes them onto dataCh, so the InfluxDB writer path is unchanged. It
+// runs until done is closed by the caller; it never reads the OS shutdown
+// signal itself, so it never races main for it.
+func PollAirGradientCloud(config *Configuration, dataCh chan airGradientData, done chan struct{}) {
+	if config.AirGradientCloud.PollInterval == 0 {
+		config.AirGradientCloud.PollInterval = 300
+	}
+
+	client, err := newAirGradientCloudClient(config)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"op":    "PollAirGradientCloud",
+			"error": err,
+		}).Error("failed to initialize AirGradient cloud API client")
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(config.AirGradientCloud.PollInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		fetchAirGradientCloud(client, config, dataCh)
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// newAirGradientCloudClient builds an *http.Client honoring
+// config.AirGradientCloud.HttpProxy, for users behind restrictive
+// networks that still need to reach the AirGradient cloud API.
+func newAirGradientCloudClient(config *Configuration) (*http.Client, error) {
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{},
+	}
+
+	if config.AirGradientCloud.HttpProxy != "" {
+		proxyUrl, err := url.Parse(config.AirGradientCloud.HttpProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AirGradientCloud.HttpProxy %s, %s", config.AirGradientCloud.HttpProxy, err)
+		}
+
+		dialer, err := proxy.FromURL(proxyUrl, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure proxy dialer for %s, %s", config.AirGradientCloud.HttpProxy, err)
+		}
+
+		transport.Dial = dialer.Dial
+	}
+
+	return &http.Client{
+		Timeout:   time.Second * 30,
+		Transport: transport,
+	}, nil
+}
+
+// fetchAirGradientCloud issues a single request to the AirGradient cloud
+// API for every configured location and pushes the decoded measurements
+// onto dataCh.
+func fetchAirGradientCloud(client *http.Client, config *Configuration, dataCh chan airGradientData) {
+	for _, location := range config.AirGradientCloud.Locations {
+		req, err := http.NewRequest(http.MethodGet, airGradientCloudUrl, nil)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"op":    "fetchAirGradientCloud",
+				"error": err,
+			}).Error("failed to build AirGradient cloud API request")
+			continue
+		}
+
+		q := req.URL.Query()
+		q.Set("token", config.AirGradientCloud.Token)
+		q.Set("locationId", location)
+		req.URL.RawQuery = q.Encode()
+
+		resp, err := client.Do(req)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"op":       "fetchAirGradientCloud",
+				"location": location,
+				"error":    err,
+			}).Error("failed to query AirGradient cloud API")
+			continue
+		}
+
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"op":       "fetchAirGradientCloud",
+				"location": location,
+				"error":    err,
+			}).Error("failed to read AirGradient cloud API response")
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			log.WithFields(log.Fields{
+				"op":       "fetchAirGradientCloud",
+				"location": location,
+				"status":   resp.StatusCode,
+			}).Error("AirGradient cloud API returned a non-200 status")
+			continue
+		}
+
+		var measurements []cloudMeasurement
+		if err := json.Unmarshal(b, &measurements); err != nil {
+			measurementsUnmarshalFailuresTotal.Inc()
+			log.WithFields(log.Fields{
+				"op":       "fetchAirGradientCloud",
+				"location": location,
+				"error":    err,
+			}).Error("failed to unmarshal AirGradient cloud API response")
+			continue
+		}
+
+		for _, m := range measurements {
+			pushMeasurement(dataCh, airGradientData{
+				Id:    fmt.Sprintf("%d", m.LocationId),
+				Ts:    time.Now(),
+				Wifi:  m.Wifi,
+				C02:   m.C02,
+				PM01:  m.PM01,
+				PM02:  m.PM02,
+				PM10:  m.PM10,
+				PM003: m.PM003,
+				TVOC:  m.TVOC,
+				NOX:   m.NOX,
+				Temp:  m.Temp,
+				Hum:   m.Hum,
+			})
+		}
+	}
+}
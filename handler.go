@@ -13,18 +13,18 @@ import (
 
 // Example point {"wifi":-64, "rco2":419, "pm01":4, "pm02":7, "pm10":7, "pm003_count":834, "tvoc_index":3`3, "nox_index":2, "atmp":32.07, "rhum":56}
 type airGradientData struct {
-	Id    string `json:"-"`
-	Ts    time.Time
-	Wifi  int     `json:"wifi"`
-	C02   int     `json:"rco2"`
-	PM01  int     `json:"pm01"`
-	PM02  int     `json:"pm02"`
-	PM10  int     `json:"pm10"`
-	PM003 int     `json:"pm003_count"`
-	TVOC  int     `json:"tvoc_index"`
-	NOX   int     `json:"nox_index"`
-	Temp  float64 `json:"atmp"`
-	Hum   int     `json:"rhum"`
+	Id    string    `json:"id,omitempty"`
+	Ts    time.Time `json:"ts"`
+	Wifi  int       `json:"wifi"`
+	C02   int       `json:"rco2"`
+	PM01  int       `json:"pm01"`
+	PM02  int       `json:"pm02"`
+	PM10  int       `json:"pm10"`
+	PM003 int       `json:"pm003_count"`
+	TVOC  int       `json:"tvoc_index"`
+	NOX   int       `json:"nox_index"`
+	Temp  float64   `json:"atmp"`
+	Hum   int       `json:"rhum"`
 }
 
 func mainHandler(dataChannel chan airGradientData) func(w http.ResponseWriter, r *http.Request) {
@@ -41,6 +41,7 @@ func mainHandler(dataChannel chan airGradientData) func(w http.ResponseWriter, r
 		// Unmarshal into airGradientData struct
 		var data airGradientData
 		if err := json.Unmarshal(b, &data); err != nil {
+			measurementsUnmarshalFailuresTotal.Inc()
 			errMsg := "failed to unmarshal"
 			log.WithError(err).Error(errMsg)
 			http.Error(w, errMsg, http.StatusBadRequest)
@@ -58,6 +59,6 @@ func mainHandler(dataChannel chan airGradientData) func(w http.ResponseWriter, r
 		}
 		data.Id = instanceId
 		data.Ts = time.Now()
-		dataChannel <- data
+		pushMeasurement(dataChannel, data)
 	}
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pushMeasurement enqueues data onto dataCh, dropping the oldest queued
+// measurement (and counting it) if the channel is full, rather than
+// blocking the caller. This keeps the HTTP handler, MQTT subscriber, and
+// cloud poller responsive even when the sink is slow or unreachable.
+func pushMeasurement(dataCh chan airGradientData, data airGradientData) {
+	for {
+		select {
+		case dataCh <- data:
+			return
+		default:
+		}
+
+		select {
+		case <-dataCh:
+			measurementsDroppedTotal.Inc()
+			log.WithFields(log.Fields{
+				"op": "pushMeasurement",
+			}).Warn("ingest channel full, dropped oldest queued measurement")
+		default:
+		}
+	}
+}
+
+// StartIngestWorkers starts config.Ingest.Workers goroutines that drain
+// dataCh, batching up to BatchSize measurements (or whatever arrives
+// within BatchTimeout) before writing the batch to sink and flushing.
+// wg is incremented once per worker and marked Done when dataCh is
+// closed and the worker has flushed its final batch, so callers can
+// block on a clean shutdown.
+func StartIngestWorkers(config *Configuration, dataCh chan airGradientData, sink Sink, cache *SensorCache, nowCastTracker *NowCastTracker, wg *sync.WaitGroup) {
+	workers := config.Ingest.Workers
+	if workers == 0 {
+		workers = 1
+	}
+
+	for i := uint(0); i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ingestWorker(config, dataCh, sink, cache, nowCastTracker)
+		}()
+	}
+}
+
+func ingestWorker(config *Configuration, dataCh chan airGradientData, sink Sink, cache *SensorCache, nowCastTracker *NowCastTracker) {
+	batchSize := config.Ingest.BatchSize
+	if batchSize == 0 {
+		batchSize = 1
+	}
+
+	batchTimeout := config.Ingest.BatchTimeout
+	if batchTimeout == 0 {
+		batchTimeout = 10
+	}
+	timeout := time.Duration(batchTimeout) * time.Second
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	batch := make([]airGradientData, 0, batchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		points := make([]Point, 0, len(batch))
+		for _, dataPoint := range batch {
+			points = append(points, prepareMeasurement(dataPoint, cache, nowCastTracker))
+		}
+
+		if err := sink.WriteBatch(points); err != nil {
+			influxWriteErrorsTotal.Inc()
+			log.WithFields(log.Fields{
+				"op":         "ingestWorker",
+				"batch_size": len(points),
+				"error":      err,
+			}).Error("encountered error writing batch to sink")
+		}
+		sink.Flush()
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case dataPoint, ok := <-dataCh:
+			if !ok {
+				flush()
+				return
+			}
+
+			batch = append(batch, dataPoint)
+			if len(batch) >= int(batchSize) {
+				flush()
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(timeout)
+			}
+		case <-timer.C:
+			flush()
+			timer.Reset(timeout)
+		}
+	}
+}
+
+// prepareMeasurement records metrics and cache state for dataPoint,
+// computes its derived air-quality indices, and returns the Point to be
+// written to the sink.
+func prepareMeasurement(dataPoint airGradientData, cache *SensorCache, nowCastTracker *NowCastTracker) Point {
+	RecordMeasurement(dataPoint)
+	cache.Update(dataPoint)
+
+	fields := map[string]interface{}{
+		"wifi":         dataPoint.Wifi,
+		"co2":          dataPoint.C02,
+		"pm1":          dataPoint.PM01,
+		"pm25":         dataPoint.PM02,
+		"pm10":         dataPoint.PM10,
+		"pm003":        dataPoint.PM003,
+		"tvoc":         dataPoint.TVOC,
+		"nox":          dataPoint.NOX,
+		"temp":         dataPoint.Temp,
+		"rel_humidity": dataPoint.Hum,
+	}
+
+	// Derived air-quality indices, skipped when there isn't enough data
+	// yet to compute them (e.g. NowCast during the first couple of
+	// hours for a sensor).
+	nowcast := nowCastTracker.Add(dataPoint.Id, dataPoint.Ts, float64(dataPoint.PM02))
+	for field, value := range map[string]float64{
+		"pm25_aqi":         PM25AQI(float64(dataPoint.PM02)),
+		"pm10_aqi":         PM10AQI(float64(dataPoint.PM10)),
+		"pm25_nowcast":     nowcast,
+		"pm25_nowcast_aqi": PM25AQI(nowcast),
+	} {
+		if !math.IsNaN(value) {
+			fields[field] = value
+		}
+	}
+
+	return Point{
+		Measurement: "air_quality",
+		Tags:        map[string]string{"id": dataPoint.Id},
+		Fields:      fields,
+		Ts:          dataPoint.Ts,
+	}
+}
@@ -1,25 +1,29 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
 	"flag"
 	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/gorilla/mux"
-	influx "github.com/influxdata/influxdb-client-go/v2"
-	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
 
 // Configuration represents a YAML-formatted config file
 type Configuration struct {
-	Server   Server
-	InfluxDB InfluxDB
+	Server           Server
+	InfluxDB         InfluxDB
+	MQTT             MQTT
+	AirGradientCloud AirGradientCloud
+	Ingest           Ingest
 }
 
 type Server struct {
@@ -38,6 +42,43 @@ type InfluxDB struct {
 	Bucket            string
 	SkipVerifySsl     bool
 	FlushInterval     uint
+	Version           uint
+}
+
+// MQTT configures an optional subscriber that ingests AirGradient
+// measurements published by devices whose firmware speaks MQTT instead
+// of (or in addition to) HTTP.
+type MQTT struct {
+	Enabled       bool
+	BrokerUrl     string
+	ClientId      string
+	Username      string
+	Password      string
+	Topic         string
+	SkipVerifySsl bool
+}
+
+// AirGradientCloud configures an optional poller that pulls current
+// measurements from the AirGradient public API instead of (or in
+// addition to) receiving them from devices directly.
+type AirGradientCloud struct {
+	Enabled      bool
+	Token        string
+	Locations    []string
+	PollInterval uint
+	HttpProxy    string
+}
+
+// Ingest configures how measurements are buffered between the
+// producers (HTTP, MQTT, cloud poller) and the sink. ChannelSize bounds
+// how many measurements may queue up before the oldest are dropped;
+// Workers, BatchSize, and BatchTimeout control how the consumer side
+// batches writes to the sink.
+type Ingest struct {
+	ChannelSize  uint
+	Workers      uint
+	BatchSize    uint
+	BatchTimeout uint
 }
 
 // Load a config file and return the Config struct
@@ -60,47 +101,6 @@ func LoadConfiguration(configPath string) (*Configuration, error) {
 	return &configuration, nil
 }
 
-type InfluxWriteConfigError struct{}
-
-func (r *InfluxWriteConfigError) Error() string {
-	return "must configure at least one of bucket or database/retention policy"
-}
-
-func InfluxConnect(config *Configuration) (influx.Client, influxAPI.WriteAPI, error) {
-	var auth string
-	if config.InfluxDB.Token != "" {
-		auth = config.InfluxDB.Token
-	} else if config.InfluxDB.Username != "" && config.InfluxDB.Password != "" {
-		auth = fmt.Sprintf("%s:%s", config.InfluxDB.Username, config.InfluxDB.Password)
-	} else {
-		auth = ""
-	}
-
-	var writeDest string
-	if config.InfluxDB.Bucket != "" {
-		writeDest = config.InfluxDB.Bucket
-	} else if config.InfluxDB.Database != "" && config.InfluxDB.RetentionPolicy != "" {
-		writeDest = fmt.Sprintf("%s/%s", config.InfluxDB.Database, config.InfluxDB.RetentionPolicy)
-	} else {
-		return nil, nil, &InfluxWriteConfigError{}
-	}
-
-	if config.InfluxDB.FlushInterval == 0 {
-		config.InfluxDB.FlushInterval = 30
-	}
-
-	options := influx.DefaultOptions().
-		SetFlushInterval(1000 * config.InfluxDB.FlushInterval).
-		SetTLSConfig(&tls.Config{
-			InsecureSkipVerify: config.InfluxDB.SkipVerifySsl,
-		})
-	client := influx.NewClientWithOptions(config.InfluxDB.Address, auth, options)
-
-	writeAPI := client.WriteAPI(config.InfluxDB.Organization, writeDest)
-
-	return client, writeAPI, nil
-}
-
 func main() {
 
 	// Load the config file based on path provided via CLI or the default
@@ -114,36 +114,72 @@ func main() {
 		}).Fatal("failed to load configuration")
 	}
 
-	// Initialize the InfluxDB connection
-	influxClient, writeAPI, err := InfluxConnect(config)
+	// Initialize the configured sink (InfluxDB v1, v2, or no-op)
+	sink, err := NewSink(config)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"op":    "main",
 			"error": err,
-		}).Fatal("failed to initialize InfluxDB connection")
+		}).Fatal("failed to initialize sink")
 	}
-	defer influxClient.Close()
-	defer writeAPI.Flush()
-
-	errorsCh := writeAPI.Errors()
+	defer sink.Close()
 
-	// Monitor InfluxDB write errors
-	go func() {
-		for err := range errorsCh {
-			log.WithFields(log.Fields{
-				"op":    "main",
-				"error": err,
-			}).Error("encountered error on writing to InfluxDB")
-		}
-	}()
+	// Initialize the querier backing /sensors/{id}/history
+	querier, err := NewQuerier(config)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"op":    "main",
+			"error": err,
+		}).Fatal("failed to initialize querier")
+	}
 
 	// Look for SIGTERM or SIGINT
 	cancelCh := make(chan os.Signal, 1)
 	signal.Notify(cancelCh, syscall.SIGTERM, syscall.SIGINT)
 
+	cache := NewSensorCache()
+	nowCastTracker := NewNowCastTracker()
+
+	channelSize := config.Ingest.ChannelSize
+	if channelSize == 0 {
+		channelSize = 100
+	}
+
 	r := mux.NewRouter()
-	dataCh := make(chan airGradientData, 1)
+	dataCh := make(chan airGradientData, channelSize)
 	r.HandleFunc("/sensors/{id}/measures", mainHandler(dataCh))
+	r.HandleFunc("/sensors", sensorsHandler(cache))
+	r.HandleFunc("/sensors/{id}/latest", sensorLatestHandler(cache))
+	r.HandleFunc("/sensors/{id}/history", sensorHistoryHandler(querier))
+	r.Handle("/metrics", promhttp.Handler())
+
+	// Optionally subscribe to an MQTT broker and feed the same dataCh used
+	// by the HTTP handler, so the InfluxDB writer path below is unchanged.
+	var mqttClient mqtt.Client
+	if config.MQTT.Enabled {
+		mqttClient, err = MQTTConnect(config, dataCh)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"op":    "main",
+				"error": err,
+			}).Fatal("failed to initialize MQTT subscriber")
+		}
+	}
+
+	// Optionally poll the AirGradient cloud API on an interval and feed
+	// the same dataCh, so users can ingest cloud/location data without
+	// exposing the local HTTP endpoint. cloudDone is owned exclusively by
+	// main, separate from cancelCh, so the two never race for the same
+	// OS signal.
+	cloudDone := make(chan struct{})
+	var cloudWg sync.WaitGroup
+	if config.AirGradientCloud.Enabled {
+		cloudWg.Add(1)
+		go func() {
+			defer cloudWg.Done()
+			PollAirGradientCloud(config, dataCh, cloudDone)
+		}()
+	}
 
 	server := &http.Server{
 		Addr:         config.Server.ListenAddr,
@@ -152,35 +188,13 @@ func main() {
 		WriteTimeout: time.Second * 10,
 	}
 
-	// Submit results to InfluxDB
-	go func() {
-		for dataPoint := range dataCh {
-			influxPoint := influx.NewPoint(
-				"air_quality",
-				map[string]string{
-					"id": dataPoint.Id,
-				},
-				map[string]interface{}{
-					"wifi":         dataPoint.Wifi,
-					"co2":          dataPoint.C02,
-					"pm1":          dataPoint.PM01,
-					"pm25":         dataPoint.PM02,
-					"pm10":         dataPoint.PM10,
-					"pm003":        dataPoint.PM003,
-					"tvoc":         dataPoint.TVOC,
-					"nox":          dataPoint.NOX,
-					"temp":         dataPoint.Temp,
-					"rel_humidity": dataPoint.Hum,
-				},
-				dataPoint.Ts,
-			)
-			writeAPI.WritePoint(influxPoint)
-		}
-	}()
+	// Submit results to the configured sink via a bounded worker pool
+	var ingestWg sync.WaitGroup
+	StartIngestWorkers(config, dataCh, sink, cache, nowCastTracker, &ingestWg)
 
 	log.Infof("listening on %s", config.Server.ListenAddr)
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.WithError(err).Error("failed to run http server")
 		}
 	}()
@@ -188,7 +202,27 @@ func main() {
 	sig := <-cancelCh
 	log.WithFields(log.Fields{
 		"op": "main",
-	}).Info(fmt.Sprintf("caught signal %v, flushing data to InfluxDB", sig))
-	writeAPI.Flush()
+	}).Info(fmt.Sprintf("caught signal %v, draining and flushing data to InfluxDB", sig))
+
+	// Stop every producer before closing dataCh, so nothing can send on
+	// it once it's closed.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.WithError(err).Error("failed to gracefully shut down http server")
+	}
+	if mqttClient != nil {
+		mqttClient.Disconnect(250)
+	}
+	if config.AirGradientCloud.Enabled {
+		close(cloudDone)
+		cloudWg.Wait()
+	}
+
+	// With every producer stopped, it's now safe to close dataCh and
+	// wait for the ingest workers to flush whatever they were holding.
+	close(dataCh)
+	ingestWg.Wait()
 
+	sink.Flush()
 }
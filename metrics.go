@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics, exposed on /metrics alongside the InfluxDB sink so
+// users running Grafana with Prometheus can dashboard AirGradient data
+// without maintaining two exporters.
+var (
+	measurementsReceivedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "airgradient_measurements_received_total",
+		Help: "Total number of measurements received from any source (HTTP, MQTT, cloud API).",
+	})
+
+	measurementsUnmarshalFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "airgradient_measurements_unmarshal_failures_total",
+		Help: "Total number of measurement payloads that failed to unmarshal.",
+	})
+
+	influxWriteErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "airgradient_influxdb_write_errors_total",
+		Help: "Total number of errors encountered while writing to InfluxDB.",
+	})
+
+	measurementsDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "airgradient_measurements_dropped_total",
+		Help: "Total number of measurements dropped because the ingest channel was full.",
+	})
+
+	sensorCo2 = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airgradient_co2",
+		Help: "Latest CO2 reading in ppm.",
+	}, []string{"id"})
+
+	sensorPm1 = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airgradient_pm1",
+		Help: "Latest PM1 reading in ug/m3.",
+	}, []string{"id"})
+
+	sensorPm25 = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airgradient_pm25",
+		Help: "Latest PM2.5 reading in ug/m3.",
+	}, []string{"id"})
+
+	sensorPm10 = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airgradient_pm10",
+		Help: "Latest PM10 reading in ug/m3.",
+	}, []string{"id"})
+
+	sensorTvoc = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airgradient_tvoc",
+		Help: "Latest TVOC index reading.",
+	}, []string{"id"})
+
+	sensorNox = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airgradient_nox",
+		Help: "Latest NOx index reading.",
+	}, []string{"id"})
+
+	sensorAtmp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airgradient_atmp",
+		Help: "Latest ambient temperature reading in degrees Celsius.",
+	}, []string{"id"})
+
+	sensorRhum = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airgradient_rhum",
+		Help: "Latest relative humidity reading in percent.",
+	}, []string{"id"})
+
+	sensorWifi = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airgradient_wifi",
+		Help: "Latest WiFi signal strength reading in dBm.",
+	}, []string{"id"})
+)
+
+// RecordMeasurement updates the per-sensor gauges and the
+// measurements-received counter for a single airGradientData point.
+func RecordMeasurement(data airGradientData) {
+	measurementsReceivedTotal.Inc()
+
+	sensorCo2.WithLabelValues(data.Id).Set(float64(data.C02))
+	sensorPm1.WithLabelValues(data.Id).Set(float64(data.PM01))
+	sensorPm25.WithLabelValues(data.Id).Set(float64(data.PM02))
+	sensorPm10.WithLabelValues(data.Id).Set(float64(data.PM10))
+	sensorTvoc.WithLabelValues(data.Id).Set(float64(data.TVOC))
+	sensorNox.WithLabelValues(data.Id).Set(float64(data.NOX))
+	sensorAtmp.WithLabelValues(data.Id).Set(data.Temp)
+	sensorRhum.WithLabelValues(data.Id).Set(float64(data.Hum))
+	sensorWifi.WithLabelValues(data.Id).Set(float64(data.Wifi))
+}
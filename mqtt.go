@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// MQTTConnect subscribes to config.MQTT.Topic and decodes every message
+// received as an airGradientData payload, pushing it onto dataCh so it
+// is written to InfluxDB exactly like measurements posted over HTTP.
+//
+// config.MQTT.Topic may contain a single `+` wildcard segment, e.g.
+// `airgradient/+/measures`, which is used both as the MQTT subscription
+// filter and to recover the sensor id from the topic of each message
+// that arrives.
+func MQTTConnect(config *Configuration, dataCh chan airGradientData) (mqtt.Client, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.MQTT.BrokerUrl).
+		SetClientID(config.MQTT.ClientId).
+		SetUsername(config.MQTT.Username).
+		SetPassword(config.MQTT.Password).
+		SetTLSConfig(&tls.Config{
+			InsecureSkipVerify: config.MQTT.SkipVerifySsl,
+		})
+
+	topic := config.MQTT.Topic
+	if topic == "" {
+		topic = "airgradient/+/measures"
+	}
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, token.Error()
+	}
+
+	if token := client.Subscribe(topic, 0, mqttMessageHandler(dataCh, topic)); token.Wait() && token.Error() != nil {
+		client.Disconnect(250)
+		return nil, token.Error()
+	}
+
+	return client, nil
+}
+
+// mqttMessageHandler decodes AirGradient JSON payloads and pushes them
+// onto dataCh, resolving the sensor id from the `+` segment of topic.
+func mqttMessageHandler(dataCh chan airGradientData, topic string) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		var data airGradientData
+		if err := json.Unmarshal(msg.Payload(), &data); err != nil {
+			measurementsUnmarshalFailuresTotal.Inc()
+			log.WithFields(log.Fields{
+				"op":    "mqttMessageHandler",
+				"topic": msg.Topic(),
+				"error": err,
+			}).Error("failed to unmarshal MQTT payload")
+			return
+		}
+
+		data.Id = idFromTopic(topic, msg.Topic())
+		data.Ts = time.Now()
+		pushMeasurement(dataCh, data)
+	}
+}
+
+// idFromTopic maps the `+` wildcard segment of template onto the
+// matching segment of topic, returning "null" if it cannot be resolved.
+func idFromTopic(template, topic string) string {
+	templateParts := strings.Split(template, "/")
+	topicParts := strings.Split(topic, "/")
+	if len(templateParts) != len(topicParts) {
+		return "null"
+	}
+
+	for i, part := range templateParts {
+		if part == "+" {
+			return topicParts[i]
+		}
+	}
+
+	return "null"
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	influx "github.com/influxdata/influxdb-client-go/v2"
+	influx1 "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Querier serves range queries against the configured InfluxDB backend
+// for the /sensors/{id}/history endpoint.
+type Querier interface {
+	History(id string, from, to time.Time) ([]airGradientData, error)
+}
+
+// NewQuerier selects and initializes a Querier based on
+// config.InfluxDB.Version, mirroring NewSink's backend selection. It
+// returns a nil Querier, with no error, when InfluxDB.Address is unset,
+// since there is then nothing to query against.
+func NewQuerier(config *Configuration) (Querier, error) {
+	if config.InfluxDB.Address == "" {
+		return nil, nil
+	}
+
+	switch config.InfluxDB.Version {
+	case 0, 2:
+		return newInfluxV2Querier(config)
+	case 1:
+		return newInfluxV1Querier(config)
+	default:
+		return nil, fmt.Errorf("unsupported InfluxDB.Version %d, must be 1 or 2", config.InfluxDB.Version)
+	}
+}
+
+type influxV2Querier struct {
+	client influx.Client
+	org    string
+	bucket string
+}
+
+func newInfluxV2Querier(config *Configuration) (Querier, error) {
+	var auth string
+	if config.InfluxDB.Token != "" {
+		auth = config.InfluxDB.Token
+	} else if config.InfluxDB.Username != "" && config.InfluxDB.Password != "" {
+		auth = fmt.Sprintf("%s:%s", config.InfluxDB.Username, config.InfluxDB.Password)
+	}
+
+	bucket, err := influxV2Bucket(config)
+	if err != nil {
+		return nil, err
+	}
+
+	options := influx.DefaultOptions().SetTLSConfig(&tls.Config{
+		InsecureSkipVerify: config.InfluxDB.SkipVerifySsl,
+	})
+	client := influx.NewClientWithOptions(config.InfluxDB.Address, auth, options)
+
+	return &influxV2Querier{
+		client: client,
+		org:    config.InfluxDB.Organization,
+		bucket: bucket,
+	}, nil
+}
+
+func (q *influxV2Querier) History(id string, from, to time.Time) ([]airGradientData, error) {
+	queryAPI := q.client.QueryAPI(q.org)
+	flux := fmt.Sprintf(
+		`from(bucket: %q) |> range(start: %s, stop: %s) |> filter(fn: (r) => r._measurement == "air_quality" and r.id == %q) |> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")`,
+		q.bucket, from.Format(time.RFC3339), to.Format(time.RFC3339), id,
+	)
+
+	result, err := queryAPI.Query(context.Background(), flux)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query InfluxDB v2, %s", err)
+	}
+	defer result.Close()
+
+	var points []airGradientData
+	for result.Next() {
+		rec := result.Record()
+		points = append(points, airGradientData{
+			Id:    id,
+			Ts:    rec.Time(),
+			Wifi:  fieldToInt(rec.ValueByKey("wifi")),
+			C02:   fieldToInt(rec.ValueByKey("co2")),
+			PM01:  fieldToInt(rec.ValueByKey("pm1")),
+			PM02:  fieldToInt(rec.ValueByKey("pm25")),
+			PM10:  fieldToInt(rec.ValueByKey("pm10")),
+			PM003: fieldToInt(rec.ValueByKey("pm003")),
+			TVOC:  fieldToInt(rec.ValueByKey("tvoc")),
+			NOX:   fieldToInt(rec.ValueByKey("nox")),
+			Temp:  fieldToFloat(rec.ValueByKey("temp")),
+			Hum:   fieldToInt(rec.ValueByKey("rel_humidity")),
+		})
+	}
+	if result.Err() != nil {
+		return nil, fmt.Errorf("failed to read InfluxDB v2 query results, %s", result.Err())
+	}
+
+	return points, nil
+}
+
+type influxV1Querier struct {
+	client   influx1.Client
+	database string
+}
+
+func newInfluxV1Querier(config *Configuration) (Querier, error) {
+	if config.InfluxDB.Database == "" {
+		return nil, &InfluxWriteConfigError{}
+	}
+
+	client, err := influx1.NewHTTPClient(influx1.HTTPConfig{
+		Addr:     config.InfluxDB.Address,
+		Username: config.InfluxDB.Username,
+		Password: config.InfluxDB.Password,
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: config.InfluxDB.SkipVerifySsl,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize InfluxDB v1 client, %s", err)
+	}
+
+	return &influxV1Querier{
+		client:   client,
+		database: config.InfluxDB.Database,
+	}, nil
+}
+
+func (q *influxV1Querier) History(id string, from, to time.Time) ([]airGradientData, error) {
+	query := fmt.Sprintf(
+		"SELECT wifi, co2, pm1, pm25, pm10, pm003, tvoc, nox, temp, rel_humidity FROM air_quality WHERE id = '%s' AND time >= '%s' AND time <= '%s'",
+		escapeInfluxQLString(id), from.Format(time.RFC3339), to.Format(time.RFC3339),
+	)
+
+	resp, err := q.client.Query(influx1.NewQuery(query, q.database, ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query InfluxDB v1, %s", err)
+	}
+	if resp.Error() != nil {
+		return nil, fmt.Errorf("InfluxDB v1 query returned an error, %s", resp.Error())
+	}
+
+	var points []airGradientData
+	for _, result := range resp.Results {
+		for _, series := range result.Series {
+			for _, row := range series.Values {
+				points = append(points, v1RowToData(id, series.Columns, row))
+			}
+		}
+	}
+
+	return points, nil
+}
+
+// escapeInfluxQLString escapes backslashes and single quotes so a value
+// can be safely interpolated into an InfluxQL single-quoted string
+// literal, per the InfluxQL string literal grammar.
+func escapeInfluxQLString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return s
+}
+
+// v1RowToData converts a single InfluxQL result row into airGradientData,
+// matching columns by name since InfluxQL does not guarantee column order.
+func v1RowToData(id string, columns []string, row []interface{}) airGradientData {
+	data := airGradientData{Id: id}
+
+	for i, column := range columns {
+		if i >= len(row) {
+			break
+		}
+
+		switch column {
+		case "time":
+			if ts, ok := row[i].(string); ok {
+				if parsed, err := time.Parse(time.RFC3339, ts); err == nil {
+					data.Ts = parsed
+				}
+			}
+		case "wifi":
+			data.Wifi = fieldToInt(row[i])
+		case "co2":
+			data.C02 = fieldToInt(row[i])
+		case "pm1":
+			data.PM01 = fieldToInt(row[i])
+		case "pm25":
+			data.PM02 = fieldToInt(row[i])
+		case "pm10":
+			data.PM10 = fieldToInt(row[i])
+		case "pm003":
+			data.PM003 = fieldToInt(row[i])
+		case "tvoc":
+			data.TVOC = fieldToInt(row[i])
+		case "nox":
+			data.NOX = fieldToInt(row[i])
+		case "temp":
+			data.Temp = fieldToFloat(row[i])
+		case "rel_humidity":
+			data.Hum = fieldToInt(row[i])
+		}
+	}
+
+	return data
+}
+
+// fieldToInt normalizes the numeric types InfluxDB client libraries use
+// for field values (int64, float64, json.Number) into an int.
+func fieldToInt(v interface{}) int {
+	switch n := v.(type) {
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	case int:
+		return n
+	case json.Number:
+		f, _ := n.Float64()
+		return int(f)
+	default:
+		return 0
+	}
+}
+
+// fieldToFloat normalizes the numeric types InfluxDB client libraries use
+// for field values into a float64.
+func fieldToFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int64:
+		return float64(n)
+	case int:
+		return float64(n)
+	case json.Number:
+		f, _ := n.Float64()
+		return f
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	influx "github.com/influxdata/influxdb-client-go/v2"
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+	influx1 "github.com/influxdata/influxdb1-client/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// Point is a single measurement ready to be written to a Sink.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Ts          time.Time
+}
+
+// Sink is the write destination for ingested measurements. It is
+// implemented by the InfluxDB v1 and v2 clients below, and by a no-op
+// sink for debugging, so the ingest workers do not need to know which
+// backend they are writing to.
+type Sink interface {
+	WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, ts time.Time) error
+	// WriteBatch writes every point as a single round trip where the
+	// backend supports it (InfluxDB v1's line protocol write), instead
+	// of one round trip per point.
+	WriteBatch(points []Point) error
+	Flush()
+	Close() error
+}
+
+type InfluxWriteConfigError struct{}
+
+func (r *InfluxWriteConfigError) Error() string {
+	return "must configure at least one of bucket or database/retention policy"
+}
+
+// NewSink selects and initializes a Sink based on config.InfluxDB.Version.
+// Version 2 (the default) uses the InfluxDB v2 client; version 1 writes
+// line protocol via influxdb1-client. Leaving InfluxDB.Address unset
+// selects the no-op sink, which logs points instead of writing them
+// anywhere, for local debugging without a live InfluxDB instance.
+func NewSink(config *Configuration) (Sink, error) {
+	if config.InfluxDB.Address == "" {
+		return newNoopSink(), nil
+	}
+
+	switch config.InfluxDB.Version {
+	case 0, 2:
+		return newInfluxV2Sink(config)
+	case 1:
+		return newInfluxV1Sink(config)
+	default:
+		return nil, fmt.Errorf("unsupported InfluxDB.Version %d, must be 1 or 2", config.InfluxDB.Version)
+	}
+}
+
+// influxV2Bucket resolves the InfluxDB v2 bucket (or v1-compat
+// "database/retentionPolicy") to write to or query against, so the sink
+// and the querier agree on exactly where a given config points.
+func influxV2Bucket(config *Configuration) (string, error) {
+	if config.InfluxDB.Bucket != "" {
+		return config.InfluxDB.Bucket, nil
+	}
+	if config.InfluxDB.Database != "" && config.InfluxDB.RetentionPolicy != "" {
+		return fmt.Sprintf("%s/%s", config.InfluxDB.Database, config.InfluxDB.RetentionPolicy), nil
+	}
+	return "", &InfluxWriteConfigError{}
+}
+
+// influxV2Sink writes points via the InfluxDB v2 client's async write API.
+type influxV2Sink struct {
+	client   influx.Client
+	writeAPI influxAPI.WriteAPI
+}
+
+func newInfluxV2Sink(config *Configuration) (Sink, error) {
+	var auth string
+	if config.InfluxDB.Token != "" {
+		auth = config.InfluxDB.Token
+	} else if config.InfluxDB.Username != "" && config.InfluxDB.Password != "" {
+		auth = fmt.Sprintf("%s:%s", config.InfluxDB.Username, config.InfluxDB.Password)
+	} else {
+		auth = ""
+	}
+
+	writeDest, err := influxV2Bucket(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.InfluxDB.FlushInterval == 0 {
+		config.InfluxDB.FlushInterval = 30
+	}
+
+	options := influx.DefaultOptions().
+		SetFlushInterval(1000 * config.InfluxDB.FlushInterval).
+		SetTLSConfig(&tls.Config{
+			InsecureSkipVerify: config.InfluxDB.SkipVerifySsl,
+		})
+	client := influx.NewClientWithOptions(config.InfluxDB.Address, auth, options)
+	writeAPI := client.WriteAPI(config.InfluxDB.Organization, writeDest)
+
+	sink := &influxV2Sink{
+		client:   client,
+		writeAPI: writeAPI,
+	}
+
+	// Monitor InfluxDB write errors
+	go func() {
+		for err := range writeAPI.Errors() {
+			influxWriteErrorsTotal.Inc()
+			log.WithFields(log.Fields{
+				"op":    "influxV2Sink",
+				"error": err,
+			}).Error("encountered error on writing to InfluxDB")
+		}
+	}()
+
+	return sink, nil
+}
+
+func (s *influxV2Sink) WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+	s.writeAPI.WritePoint(influx.NewPoint(measurement, tags, fields, ts))
+	return nil
+}
+
+// WriteBatch queues every point on the v2 client's async write API,
+// which already batches and flushes internally, so this is just a loop.
+func (s *influxV2Sink) WriteBatch(points []Point) error {
+	for _, p := range points {
+		if err := s.WritePoint(p.Measurement, p.Tags, p.Fields, p.Ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *influxV2Sink) Flush() {
+	s.writeAPI.Flush()
+}
+
+func (s *influxV2Sink) Close() error {
+	s.writeAPI.Flush()
+	s.client.Close()
+	return nil
+}
+
+// influxV1Sink writes points synchronously via influxdb1-client, for
+// users still running InfluxDB 1.x.
+type influxV1Sink struct {
+	client          influx1.Client
+	database        string
+	retentionPolicy string
+}
+
+func newInfluxV1Sink(config *Configuration) (Sink, error) {
+	if config.InfluxDB.Database == "" {
+		return nil, &InfluxWriteConfigError{}
+	}
+
+	client, err := influx1.NewHTTPClient(influx1.HTTPConfig{
+		Addr:     config.InfluxDB.Address,
+		Username: config.InfluxDB.Username,
+		Password: config.InfluxDB.Password,
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: config.InfluxDB.SkipVerifySsl,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize InfluxDB v1 client, %s", err)
+	}
+
+	return &influxV1Sink{
+		client:          client,
+		database:        config.InfluxDB.Database,
+		retentionPolicy: config.InfluxDB.RetentionPolicy,
+	}, nil
+}
+
+func (s *influxV1Sink) WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+	return s.WriteBatch([]Point{{Measurement: measurement, Tags: tags, Fields: fields, Ts: ts}})
+}
+
+// WriteBatch writes every point as a single InfluxDB v1 batch, i.e. one
+// HTTP round trip regardless of how many points are in it.
+func (s *influxV1Sink) WriteBatch(points []Point) error {
+	bp, err := influx1.NewBatchPoints(influx1.BatchPointsConfig{
+		Database:        s.database,
+		RetentionPolicy: s.retentionPolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create InfluxDB v1 batch, %s", err)
+	}
+
+	for _, p := range points {
+		pt, err := influx1.NewPoint(p.Measurement, p.Tags, p.Fields, p.Ts)
+		if err != nil {
+			return fmt.Errorf("unable to create InfluxDB v1 point, %s", err)
+		}
+		bp.AddPoint(pt)
+	}
+
+	if err := s.client.Write(bp); err != nil {
+		return fmt.Errorf("unable to write InfluxDB v1 batch, %s", err)
+	}
+
+	return nil
+}
+
+func (s *influxV1Sink) Flush() {}
+
+func (s *influxV1Sink) Close() error {
+	return s.client.Close()
+}
+
+// noopSink logs every point instead of writing it anywhere, for local
+// debugging without a live InfluxDB instance.
+type noopSink struct{}
+
+func newNoopSink() Sink {
+	return &noopSink{}
+}
+
+func (s *noopSink) WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+	log.WithFields(log.Fields{
+		"op":          "noopSink",
+		"measurement": measurement,
+		"tags":        tags,
+		"fields":      fields,
+		"ts":          ts,
+	}).Info("discarding point (no-op sink)")
+	return nil
+}
+
+// WriteBatch logs every point individually; there is nowhere to batch
+// the round trip to.
+func (s *noopSink) WriteBatch(points []Point) error {
+	for _, p := range points {
+		if err := s.WritePoint(p.Measurement, p.Tags, p.Fields, p.Ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *noopSink) Flush() {}
+
+func (s *noopSink) Close() error {
+	return nil
+}